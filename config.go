@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// feedConfig describes one feed and how it should be handled: where its
+// updates get delivered, how it's labelled, and which items to keep.
+// It's the unit parsed out of the feeds file, whether that file is the
+// legacy newline-delimited list, a YAML config, or an imported OPML
+// subscription list.
+type feedConfig struct {
+	URL           string        `yaml:"url"`
+	TitleOverride string        `yaml:"title-override,omitempty"`
+	To            []string      `yaml:"to,omitempty"`
+	Category      string        `yaml:"category,omitempty"`
+	Template      string        `yaml:"template,omitempty"`
+	MinInterval   time.Duration `yaml:"min-interval,omitempty"`
+	Sinks         []string      `yaml:"sinks,omitempty"`
+
+	// Include and Exclude are regexps matched against an item's title
+	// and tags (rss.Item.Categories); see matches.
+	Include string `yaml:"include,omitempty"`
+	Exclude string `yaml:"exclude,omitempty"`
+
+	// FetchContent overrides -fetch-content for this feed when set: true
+	// fetches full article bodies even if -fetch-content is off, false
+	// opts this feed out even if -fetch-content is on.
+	FetchContent *bool `yaml:"fetch-content,omitempty"`
+
+	// Folder overrides -imap-folder for this feed's IMAP deliveries.
+	Folder string `yaml:"folder,omitempty"`
+
+	includeRe *regexp.Regexp
+	excludeRe *regexp.Regexp
+}
+
+// fetchContent reports whether this feed should have its items' full
+// article content fetched, applying its override (if any) over the
+// -fetch-content default.
+func (fc *feedConfig) fetchContent() bool {
+	if fc.FetchContent != nil {
+		return *fc.FetchContent
+	}
+	return flags.FetchContent
+}
+
+// feedConfigYAML mirrors feedConfig for YAML (de)serialization, with
+// MinInterval as a parseable string (e.g. "30m") since yaml.v2 can't
+// unmarshal a duration string straight into a time.Duration.
+type feedConfigYAML struct {
+	URL           string   `yaml:"url"`
+	TitleOverride string   `yaml:"title-override,omitempty"`
+	To            []string `yaml:"to,omitempty"`
+	Category      string   `yaml:"category,omitempty"`
+	Template      string   `yaml:"template,omitempty"`
+	MinInterval   string   `yaml:"min-interval,omitempty"`
+	Sinks         []string `yaml:"sinks,omitempty"`
+	Include       string   `yaml:"include,omitempty"`
+	Exclude       string   `yaml:"exclude,omitempty"`
+	FetchContent  *bool    `yaml:"fetch-content,omitempty"`
+}
+
+func (fc *feedConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw feedConfigYAML
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+
+	fc.URL = raw.URL
+	fc.TitleOverride = raw.TitleOverride
+	fc.To = raw.To
+	fc.Category = raw.Category
+	fc.Template = raw.Template
+	fc.Sinks = raw.Sinks
+	fc.Include = raw.Include
+	fc.Exclude = raw.Exclude
+	fc.FetchContent = raw.FetchContent
+
+	if raw.MinInterval != "" {
+		d, err := time.ParseDuration(raw.MinInterval)
+		if err != nil {
+			return fmt.Errorf("min-interval: %w", err)
+		}
+		fc.MinInterval = d
+	}
+	return nil
+}
+
+func (fc feedConfig) MarshalYAML() (interface{}, error) {
+	raw := feedConfigYAML{
+		URL:           fc.URL,
+		TitleOverride: fc.TitleOverride,
+		To:            fc.To,
+		Category:      fc.Category,
+		Template:      fc.Template,
+		Sinks:         fc.Sinks,
+		Include:       fc.Include,
+		Exclude:       fc.Exclude,
+		FetchContent:  fc.FetchContent,
+	}
+	if fc.MinInterval > 0 {
+		raw.MinInterval = fc.MinInterval.String()
+	}
+	return raw, nil
+}
+
+// title returns the feed's display title, preferring TitleOverride.
+func (fc *feedConfig) title(fallback string) string {
+	if fc.TitleOverride != "" {
+		return fc.TitleOverride
+	}
+	return fallback
+}
+
+// compileFilters compiles Include/Exclude once up front so every item
+// check isn't recompiling a regexp.
+func (fc *feedConfig) compileFilters() error {
+	if fc.Include != "" {
+		re, err := regexp.Compile(fc.Include)
+		if err != nil {
+			return fmt.Errorf("include filter: %w", err)
+		}
+		fc.includeRe = re
+	}
+	if fc.Exclude != "" {
+		re, err := regexp.Compile(fc.Exclude)
+		if err != nil {
+			return fmt.Errorf("exclude filter: %w", err)
+		}
+		fc.excludeRe = re
+	}
+	return nil
+}
+
+// matches reports whether an item passes this feed's include and
+// exclude filters, checked against both its title and its tags (no
+// filter of a given kind means it always passes that check).
+func (fc *feedConfig) matches(title string, tags []string) bool {
+	if fc.includeRe != nil && !matchesTitleOrTags(fc.includeRe, title, tags) {
+		return false
+	}
+	if fc.excludeRe != nil && matchesTitleOrTags(fc.excludeRe, title, tags) {
+		return false
+	}
+	return true
+}
+
+// matchesTitleOrTags reports whether re matches title or any of tags.
+func matchesTitleOrTags(re *regexp.Regexp, title string, tags []string) bool {
+	if re.MatchString(title) {
+		return true
+	}
+	for _, tag := range tags {
+		if re.MatchString(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseFeedsFile reads feedsFile, auto-detecting its format from the
+// file extension: .yaml/.yml for the structured config, .opml/.xml for
+// an imported subscription list, and anything else for the legacy
+// newline-delimited feed list.
+func parseFeedsFile() ([]*feedConfig, error) {
+	// Don't parse if it doesn't exist. We do this here because it's not
+	// an error we want to report.
+	if _, err := os.Stat(feedsFile); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(feedsFile)
+	if err != nil {
+		return nil, fmt.Errorf("opening feedsFile %q: %w", feedsFile, err)
+	}
+
+	var feeds []*feedConfig
+	switch strings.ToLower(path.Ext(feedsFile)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &feeds); err != nil {
+			return nil, fmt.Errorf("parsing feedsFile %q as YAML: %w", feedsFile, err)
+		}
+	case ".opml", ".xml":
+		if feeds, err = parseOPMLFeeds(data); err != nil {
+			return nil, fmt.Errorf("parsing feedsFile %q as OPML: %w", feedsFile, err)
+		}
+	default:
+		feeds = parseLegacyFeeds(data)
+	}
+
+	for _, fc := range feeds {
+		if err := fc.compileFilters(); err != nil {
+			return nil, fmt.Errorf("feed %q: %w", fc.URL, err)
+		}
+	}
+	return feeds, nil
+}
+
+// parseLegacyFeeds parses the original feeds file format: one feed URL
+// per line, with an optional "|sink1,sink2" suffix restricting which
+// -notify sinks that feed is routed to.
+func parseLegacyFeeds(data []byte) []*feedConfig {
+	var feeds []*feedConfig
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		url, sinks := splitFeedLine(line)
+		feeds = append(feeds, &feedConfig{URL: url, Sinks: sinks})
+	}
+	return feeds
+}
+
+// splitFeedLine splits a legacy feeds file line of the form "url" or
+// "url|sink1,sink2" into the feed URL and the -notify sinks it should be
+// routed to (nil meaning "all of them").
+func splitFeedLine(line string) (string, []string) {
+	parts := strings.SplitN(line, "|", 2)
+	if len(parts) == 1 {
+		return strings.TrimSpace(parts[0]), nil
+	}
+
+	var sinks []string
+	for _, s := range strings.Split(parts[1], ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			sinks = append(sinks, s)
+		}
+	}
+	return strings.TrimSpace(parts[0]), sinks
+}
+
+// feedsToYAML renders feeds as a YAML feeds file, e.g. for printing the
+// result of an OPML import.
+func feedsToYAML(feeds []*feedConfig) ([]byte, error) {
+	return yaml.Marshal(feeds)
+}
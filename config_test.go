@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseLegacyFeeds(t *testing.T) {
+	data := []byte("\nhttp://example.com/a\nhttp://example.com/b|stdout,smtp\n  http://example.com/c  |  stdout  \n")
+	feeds := parseLegacyFeeds(data)
+	if len(feeds) != 3 {
+		t.Fatalf("got %d feeds, want 3", len(feeds))
+	}
+	if feeds[0].URL != "http://example.com/a" || feeds[0].Sinks != nil {
+		t.Errorf("feed 0 = %+v, want bare URL with no sinks", feeds[0])
+	}
+	if feeds[1].URL != "http://example.com/b" || len(feeds[1].Sinks) != 2 {
+		t.Errorf("feed 1 = %+v, want two sinks", feeds[1])
+	}
+	if feeds[2].URL != "http://example.com/c" || len(feeds[2].Sinks) != 1 || feeds[2].Sinks[0] != "stdout" {
+		t.Errorf("feed 2 = %+v, want trimmed URL and one sink", feeds[2])
+	}
+}
+
+func TestParseFeedsFileYAML(t *testing.T) {
+	yamlData := `
+- url: http://example.com/feed
+  title-override: Example
+  to: [a@x.com]
+  min-interval: 30m
+  include: foo
+`
+	dir := t.TempDir()
+	path := filepath.Join(dir, "feeds.yaml")
+	if err := os.WriteFile(path, []byte(yamlData), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	orig := feedsFile
+	feedsFile = path
+	defer func() { feedsFile = orig }()
+
+	feeds, err := parseFeedsFile()
+	if err != nil {
+		t.Fatalf("parseFeedsFile: %v", err)
+	}
+	if len(feeds) != 1 {
+		t.Fatalf("got %d feeds, want 1", len(feeds))
+	}
+	fc := feeds[0]
+	if fc.URL != "http://example.com/feed" || fc.TitleOverride != "Example" {
+		t.Errorf("feed = %+v, want parsed url/title-override", fc)
+	}
+	if fc.MinInterval.String() != "30m0s" {
+		t.Errorf("MinInterval = %v, want 30m0s", fc.MinInterval)
+	}
+	if fc.includeRe == nil || !fc.includeRe.MatchString("foobar") {
+		t.Errorf("include filter wasn't compiled from the parsed config")
+	}
+}
+
+func TestParseFeedsFileMissing(t *testing.T) {
+	orig := feedsFile
+	feedsFile = filepath.Join(t.TempDir(), "does-not-exist.yaml")
+	defer func() { feedsFile = orig }()
+
+	feeds, err := parseFeedsFile()
+	if err != nil || feeds != nil {
+		t.Errorf("parseFeedsFile(missing) = %v, %v, want nil, nil", feeds, err)
+	}
+}
+
+func TestCompileFiltersBadRegexp(t *testing.T) {
+	fc := &feedConfig{Include: "("}
+	if err := fc.compileFilters(); err == nil {
+		t.Fatal("expected an error compiling an invalid include regexp")
+	}
+}
+
+func TestFeedConfigMatches(t *testing.T) {
+	fc := &feedConfig{Include: "release", Exclude: "beta"}
+	if err := fc.compileFilters(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		title string
+		tags  []string
+		want  bool
+	}{
+		{"matches via title", "new release out", nil, true},
+		{"matches via tag", "announcement", []string{"release"}, true},
+		{"excluded via title", "release beta build", nil, false},
+		{"excluded via tag", "new release", []string{"beta"}, false},
+		{"fails include", "just an announcement", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fc.matches(tt.title, tt.tags); got != tt.want {
+				t.Errorf("matches(%q, %v) = %v, want %v", tt.title, tt.tags, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFeedConfigMatchesNoFilters(t *testing.T) {
+	fc := &feedConfig{}
+	if !fc.matches("anything", []string{"whatever"}) {
+		t.Error("matches with no filters configured should always pass")
+	}
+}
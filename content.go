@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	texttemplate "text/template"
+	"time"
+
+	md "github.com/JohannesKaufmann/html-to-markdown"
+	"github.com/SlyMarbo/rss"
+	"github.com/boltdb/bolt"
+	readability "github.com/go-shiori/go-readability"
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// contentBucket is the bolt bucket we cache fetched/converted article
+// bodies in, keyed by item ID, so retries don't refetch them.
+const contentBucket = "content"
+
+// contentFetchTimeout bounds how long we'll wait on a single article
+// fetch; a slow or hanging article shouldn't stall the whole batch.
+const contentFetchTimeout = 30 * time.Second
+
+// enrichedItem wraps an rss.Item with its optional full article body,
+// fetched and converted by enrichUpdates when a feed opts into
+// -fetch-content.
+type enrichedItem struct {
+	*rss.Item
+	ContentMarkdown string
+	ContentHTML     template.HTML
+}
+
+// fetchedContent is what we cache per item ID in contentBucket.
+type fetchedContent struct {
+	Markdown string `json:"markdown"`
+	HTML     string `json:"html"`
+}
+
+// contentConverter turns the readability-extracted article HTML into
+// Markdown for the digest e-mail's plaintext part.
+var contentConverter = md.NewConverter("", true, nil)
+
+// contentSanitizer strips anything readability's extraction left behind
+// that shouldn't be trusted as pre-escaped HTML -- event-handler
+// attributes, inline style/SVG, etc. -- since article.Content comes from
+// an arbitrary, possibly-hostile, third-party URL.
+var contentSanitizer = bluemonday.UGCPolicy()
+
+// textDigestTemplate and htmlDigestTemplate render a digest's
+// plaintext/HTML parts when at least one item has fetched content,
+// producing a multipart/alternative e-mail instead of the plain
+// link-only digest; see smtpNotifier.Notify.
+var (
+	textDigestTemplate = texttemplate.Must(texttemplate.New("digest-text").Funcs(texttemplate.FuncMap(templateFuncs)).Parse(`
+{{range .Updates}}
+* {{.Title}}
+{{range .Items}}
+{{.Date | formattedDate}} - {{.Title}}
+{{.Link}}
+{{if .ContentMarkdown}}
+{{.ContentMarkdown}}
+{{end}}
+{{end}}
+
+{{end}}
+`))
+
+	htmlDigestTemplate = template.Must(template.New("digest-html").Funcs(templateFuncs).Parse(`<html><body>
+{{range .Updates}}
+<h2>{{.Title}}</h2>
+{{range .Items}}
+<h3><a href="{{.Link}}">{{.Title}}</a></h3>
+<p>{{.Date | formattedDate}}</p>
+{{if .ContentHTML}}
+{{.ContentHTML}}
+{{else}}
+<p><a href="{{.Link}}">{{.Link}}</a></p>
+{{end}}
+{{end}}
+{{end}}
+</body></html>
+`))
+)
+
+// enrichUpdates fetches full article content for every item belonging
+// to an update whose feed opted into -fetch-content.
+func enrichUpdates(db *bolt.DB, updates []*update) {
+	for _, u := range updates {
+		if u.FetchContent {
+			enrichUpdateContent(db, u)
+		}
+	}
+}
+
+// enrichUpdateContent fetches and caches full article content for every
+// item in u, logging (rather than aborting on) individual failures.
+func enrichUpdateContent(db *bolt.DB, u *update) {
+	for _, item := range u.Items {
+		if err := fetchItemContent(db, item); err != nil {
+			fmt.Fprintf(os.Stderr, "failed fetching content for %q: %v\n", item.Link, err)
+		}
+	}
+}
+
+// fetchItemContent fills in item's ContentMarkdown/ContentHTML, either
+// from contentBucket's cache or by fetching item.Link, extracting the
+// main article body readability-style, sanitizing it, and converting it
+// to Markdown.
+func fetchItemContent(db *bolt.DB, item *enrichedItem) error {
+	if cached, ok := loadContent(db, item.ID); ok {
+		item.ContentMarkdown = cached.Markdown
+		item.ContentHTML = template.HTML(cached.HTML)
+		return nil
+	}
+
+	link, err := url.Parse(item.Link)
+	if err != nil {
+		return fmt.Errorf("parsing link: %w", err)
+	}
+
+	client := http.Client{Timeout: contentFetchTimeout}
+	resp, err := client.Get(item.Link)
+	if err != nil {
+		return fmt.Errorf("fetching: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("fetching: unexpected status %s", resp.Status)
+	}
+
+	article, err := readability.FromReader(io.LimitReader(resp.Body, flags.MaxContentBytes), link)
+	if err != nil {
+		return fmt.Errorf("extracting article: %w", err)
+	}
+
+	sanitized := contentSanitizer.Sanitize(article.Content)
+
+	markdown, err := contentConverter.ConvertString(sanitized)
+	if err != nil {
+		return fmt.Errorf("converting to markdown: %w", err)
+	}
+
+	item.ContentMarkdown = markdown
+	item.ContentHTML = template.HTML(sanitized)
+	return saveContent(db, item.ID, fetchedContent{Markdown: markdown, HTML: sanitized})
+}
+
+// loadContent reads a cached fetchedContent for item ID id, if any.
+func loadContent(db *bolt.DB, id string) (fetchedContent, bool) {
+	var fc fetchedContent
+	var found bool
+	db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(contentBucket))
+		if b == nil {
+			return nil
+		}
+		data := b.Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+		found = json.Unmarshal(data, &fc) == nil
+		return nil
+	})
+	return fc, found
+}
+
+// saveContent caches a fetchedContent for item ID id.
+func saveContent(db *bolt.DB, id string, fc fetchedContent) error {
+	data, err := json.Marshal(fc)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(contentBucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(id), data)
+	})
+}
+
+// hasContent reports whether any item across updates has fetched
+// content, so the notifier knows whether to render a multipart/
+// alternative e-mail instead of the plain link-only digest.
+func hasContent(updates []*update) bool {
+	for _, u := range updates {
+		for _, item := range u.Items {
+			if item.ContentMarkdown != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/SlyMarbo/rss"
+	"github.com/boltdb/bolt"
+)
+
+func TestFetchItemContent(t *testing.T) {
+	orig := flags.MaxContentBytes
+	flags.MaxContentBytes = 1 << 20
+	defer func() { flags.MaxContentBytes = orig }()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><head><title>Article</title></head><body>
+<article><h1>Article</h1><p onclick="alert(1)">Hello <b>world</b></p></article>
+</body></html>`))
+	}))
+	defer srv.Close()
+
+	db := openTestDB(t)
+
+	item := &enrichedItem{Item: &rss.Item{ID: "1", Link: srv.URL}}
+	if err := fetchItemContent(db, item); err != nil {
+		t.Fatalf("fetchItemContent: %v", err)
+	}
+
+	if strings.Contains(string(item.ContentHTML), "onclick") {
+		t.Errorf("ContentHTML = %q, want the onclick handler stripped", item.ContentHTML)
+	}
+	if !strings.Contains(item.ContentMarkdown, "Hello") || !strings.Contains(item.ContentMarkdown, "world") {
+		t.Errorf("ContentMarkdown = %q, want it to contain the article text", item.ContentMarkdown)
+	}
+
+	if _, ok := loadContent(db, "1"); !ok {
+		t.Error("fetchItemContent didn't cache the result in contentBucket")
+	}
+
+	// A second call should hit the cache rather than the server; close
+	// the server first so a real re-fetch would fail loudly.
+	srv.Close()
+	item2 := &enrichedItem{Item: &rss.Item{ID: "1", Link: srv.URL}}
+	if err := fetchItemContent(db, item2); err != nil {
+		t.Fatalf("fetchItemContent (cached): %v", err)
+	}
+	if item2.ContentMarkdown != item.ContentMarkdown {
+		t.Errorf("cached ContentMarkdown = %q, want %q", item2.ContentMarkdown, item.ContentMarkdown)
+	}
+}
+
+func TestFetchItemContentBadStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	db := openTestDB(t)
+	item := &enrichedItem{Item: &rss.Item{ID: "1", Link: srv.URL}}
+	if err := fetchItemContent(db, item); err == nil {
+		t.Fatal("expected an error for a non-2xx response")
+	}
+}
+
+func TestHasContent(t *testing.T) {
+	withContent := []*update{{Items: []*enrichedItem{{Item: &rss.Item{ID: "1"}, ContentMarkdown: "x"}}}}
+	withoutContent := []*update{{Items: []*enrichedItem{{Item: &rss.Item{ID: "1"}}}}}
+
+	if !hasContent(withContent) {
+		t.Error("hasContent = false, want true when an item has fetched content")
+	}
+	if hasContent(withoutContent) {
+		t.Error("hasContent = true, want false when no item has fetched content")
+	}
+}
+
+// openTestDB opens a throwaway bolt database in a temp directory, closed
+// automatically when the test finishes.
+func openTestDB(t *testing.T) *bolt.DB {
+	t.Helper()
+	db, err := bolt.Open(t.TempDir()+"/test.db", 0o600, nil)
+	if err != nil {
+		t.Fatalf("opening test db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SlyMarbo/rss"
+	"github.com/boltdb/bolt"
+)
+
+// feedFetchTimeout bounds how long a single feed fetch can take, so one
+// slow or hanging feed occupies a -fetchers goroutine for only so long.
+const feedFetchTimeout = 30 * time.Second
+
+// statusBucket is the bolt bucket we use to track per-feed failure and
+// backoff state in daemon mode, separate from the per-feed buckets that
+// track which item IDs we've already seen.
+const statusBucket = "status"
+
+// feedStatus is the per-feed state we persist in statusBucket and expose
+// on the -status-addr endpoint.
+type feedStatus struct {
+	URL         string    `json:"url"`
+	Title       string    `json:"title,omitempty"`
+	LastFetch   time.Time `json:"last_fetch,omitempty"`
+	LastSuccess time.Time `json:"last_success,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+	ItemCount   int       `json:"item_count"`
+	Failures    int       `json:"failures"`
+	NextFetch   time.Time `json:"next_fetch,omitempty"`
+}
+
+// runDaemon polls feeds continuously: a scheduler goroutine enqueues any
+// feed whose NextFetch has passed, and isn't already being fetched,
+// onto a bounded pool of fetcher goroutines (similar to the readeef
+// Thumbnailer worker pool); a separate goroutine coalesces the updates
+// they find into digest e-mails on a -digest interval instead of
+// sending one per fetch.
+func runDaemon(db *bolt.DB, feeds []*feedConfig) error {
+	if len(feeds) == 0 {
+		return fmt.Errorf("no feeds to poll")
+	}
+
+	notifiers, err := buildNotifiers(flags.Notify)
+	if err != nil {
+		return fmt.Errorf("configuring notifiers: %w", err)
+	}
+
+	numFetchers := flags.Fetchers
+	if numFetchers < 1 {
+		numFetchers = 1
+	}
+
+	// time.NewTicker panics on a non-positive interval, and -digest is a
+	// directly user-settable flag, so clamp it the same way numFetchers
+	// clamps -fetchers above.
+	digestInterval := flags.Digest
+	if digestInterval <= 0 {
+		digestInterval = time.Hour
+	}
+
+	jobs := make(chan *feedConfig)
+	pending := make(chan *update)
+	tracker := newFetchTracker()
+
+	for i := 0; i < numFetchers; i++ {
+		go fetchWorker(db, jobs, pending, tracker)
+	}
+
+	go collectDigests(pending, notifiers, digestInterval)
+
+	if flags.StatusAddr != "" {
+		go serveStatus(db, flags.StatusAddr)
+	}
+
+	schedule := time.NewTicker(time.Second)
+	defer schedule.Stop()
+	for range schedule.C {
+		now := time.Now()
+		for _, fc := range feeds {
+			due := true
+			db.View(func(tx *bolt.Tx) error {
+				due = !loadStatus(tx, fc.URL).NextFetch.After(now)
+				return nil
+			})
+			// NextFetch isn't updated until pollFeed's fetch returns, so
+			// without this check a slow or hanging feed would still
+			// look due on every tick and get redispatched again and
+			// again, potentially occupying every one of the -fetchers
+			// goroutines with duplicate fetches of the same URL.
+			if due && tracker.start(fc.URL) {
+				jobs <- fc
+			}
+		}
+	}
+	return nil
+}
+
+// fetchWorker is one of the bounded pool of fetchers; it pulls feeds off
+// jobs, polls them, and forwards any resulting update to pending.
+func fetchWorker(db *bolt.DB, jobs <-chan *feedConfig, pending chan<- *update, tracker *fetchTracker) {
+	for fc := range jobs {
+		upd, err := pollFeed(db, fc)
+		tracker.done(fc.URL)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed polling feed '%v': %v\n", fc.URL, err)
+			continue
+		}
+		if upd != nil {
+			pending <- upd
+		}
+	}
+}
+
+// fetchTracker tracks which feed URLs currently have a fetch in flight,
+// so the scheduler can skip a feed it's already dispatched rather than
+// queuing duplicate concurrent fetches of the same URL.
+type fetchTracker struct {
+	mu       sync.Mutex
+	inFlight map[string]bool
+}
+
+func newFetchTracker() *fetchTracker {
+	return &fetchTracker{inFlight: make(map[string]bool)}
+}
+
+// start claims url for fetching, returning false if it's already in
+// flight.
+func (t *fetchTracker) start(url string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.inFlight[url] {
+		return false
+	}
+	t.inFlight[url] = true
+	return true
+}
+
+// done releases url once its fetch has completed.
+func (t *fetchTracker) done(url string) {
+	t.mu.Lock()
+	delete(t.inFlight, url)
+	t.mu.Unlock()
+}
+
+// pollFeed fetches a single feed, records any new items, and updates its
+// status (including backoff on failure -- honoring a Retry-After the
+// server sent before falling back to a fixed doubling -- and the
+// next-fetch time the feed itself advertises via <ttl>, or failing
+// that its response's Cache-Control: max-age, when it succeeds).
+//
+// The fetch runs outside any bolt transaction: boltDB serializes every
+// writer on a single process-wide lock for the duration of db.Update, so
+// doing the network call inside one would mean only one of the
+// -fetchers goroutines is ever actually fetching at a time.
+func pollFeed(db *bolt.DB, fc *feedConfig) (*update, error) {
+	lastFetch := time.Now()
+	f, retryAfter, maxAge, fetchErr := fetchFeed(fc.URL)
+
+	var upd *update
+	err := db.Update(func(tx *bolt.Tx) error {
+		st := loadStatus(tx, fc.URL)
+		st.LastFetch = lastFetch
+
+		if fetchErr != nil {
+			st.Failures++
+			st.LastError = fetchErr.Error()
+			if retryAfter > 0 {
+				st.NextFetch = lastFetch.Add(retryAfter)
+			} else {
+				st.NextFetch = backoff(st.Failures)
+			}
+			return saveStatus(tx, st)
+		}
+
+		bucket, err := tx.CreateBucketIfNotExists([]byte(f.UpdateURL))
+		if err != nil {
+			return err
+		}
+		u := collectUpdate(bucket, f, fc)
+
+		st.URL = fc.URL
+		st.Title = u.Title
+		st.Failures = 0
+		st.LastError = ""
+		st.LastSuccess = st.LastFetch
+		st.ItemCount = len(f.Items)
+		st.NextFetch = nextFetch(fc, f, maxAge)
+		if err := saveStatus(tx, st); err != nil {
+			return err
+		}
+
+		if len(u.Items) > 0 {
+			upd = u
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Content fetching happens over the network, so it runs after the
+	// bolt write transaction above has committed rather than inside it.
+	if upd != nil && upd.FetchContent {
+		enrichUpdateContent(db, upd)
+	}
+	return upd, nil
+}
+
+// fetchFeed fetches and parses the feed at url. It returns the
+// Retry-After duration a failing response asked us to wait (zero if
+// none was sent, or the fetch never got a response at all), so pollFeed
+// can honor it instead of always falling back to backoff's fixed
+// doubling, and on success the response's Cache-Control: max-age (zero
+// if absent or unparseable), so nextFetch can fall back to it when the
+// feed itself doesn't advertise a <ttl>.
+func fetchFeed(url string) (*rss.Feed, time.Duration, time.Duration, error) {
+	client := http.Client{Timeout: feedFetchTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		retryAfter := retryAfterDuration(resp.Header.Get("Retry-After"))
+		resp.Body.Close()
+		return nil, retryAfter, 0, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	maxAge := cacheControlMaxAge(resp.Header.Get("Cache-Control"))
+	f, err := rss.FetchByFunc(func(string) (*http.Response, error) { return resp, nil }, url)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return f, 0, maxAge, nil
+}
+
+// retryAfterDuration parses an HTTP Retry-After header value -- either a
+// number of seconds or an HTTP-date -- returning zero if it's absent,
+// unparseable, or already in the past.
+func retryAfterDuration(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// cacheControlMaxAge parses an HTTP Cache-Control header value for its
+// max-age directive, returning zero if it's absent or unparseable.
+func cacheControlMaxAge(v string) time.Duration {
+	for _, directive := range strings.Split(v, ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(directive), "=")
+		if !found || !strings.EqualFold(strings.TrimSpace(name), "max-age") {
+			continue
+		}
+		secs, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || secs <= 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	return 0
+}
+
+// nextFetch picks the next time a feed should be polled, preferring the
+// interval the feed itself advertises (SlyMarbo/rss surfaces a feed's
+// <ttl> element, or its own 12h default, as Feed.Refresh), then its
+// response's Cache-Control: max-age when the feed doesn't advertise its
+// own <ttl>, falling back to -poll-interval, and never polling sooner
+// than the feed's own min-interval.
+func nextFetch(fc *feedConfig, f *rss.Feed, maxAge time.Duration) time.Time {
+	next := time.Now().Add(flags.PollInterval)
+	switch {
+	case !f.Refresh.IsZero() && f.Refresh.After(time.Now()):
+		next = f.Refresh
+	case maxAge > 0:
+		next = time.Now().Add(maxAge)
+	}
+	if floor := time.Now().Add(fc.MinInterval); fc.MinInterval > 0 && floor.After(next) {
+		next = floor
+	}
+	return next
+}
+
+// backoff doubles -poll-interval per consecutive failure, capped at an
+// hour, so a feed that's down doesn't get hammered every second by the
+// scheduler.
+func backoff(failures int) time.Time {
+	d := flags.PollInterval
+	for i := 0; i < failures && d < time.Hour; i++ {
+		d *= 2
+	}
+	if d > time.Hour {
+		d = time.Hour
+	}
+	return time.Now().Add(d)
+}
+
+// collectDigests batches updates coming from the fetcher pool and
+// flushes them to the configured notifiers every interval (normally
+// -digest, already clamped to a positive value by the caller), instead
+// of notifying once per fetch.
+func collectDigests(pending <-chan *update, notifiers []namedNotifier, interval time.Duration) {
+	var mu sync.Mutex
+	var updates []*update
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case u := <-pending:
+			mu.Lock()
+			updates = append(updates, u)
+			mu.Unlock()
+		case <-ticker.C:
+			mu.Lock()
+			batch := updates
+			updates = nil
+			mu.Unlock()
+
+			if len(batch) == 0 {
+				continue
+			}
+			if err := notifyAll(context.Background(), notifiers, batch); err != nil {
+				fmt.Fprintf(os.Stderr, "failed sending digest: %v\n", err)
+			}
+		}
+	}
+}
+
+// loadStatus reads a feed's status from statusBucket, returning a zero
+// value (with NextFetch left at the zero time, i.e. immediately due) if
+// it's never been polled.
+func loadStatus(tx *bolt.Tx, feed string) feedStatus {
+	st := feedStatus{URL: feed}
+	b := tx.Bucket([]byte(statusBucket))
+	if b == nil {
+		return st
+	}
+	if data := b.Get([]byte(feed)); data != nil {
+		json.Unmarshal(data, &st)
+	}
+	return st
+}
+
+// saveStatus writes a feed's status to statusBucket, creating it on
+// first use.
+func saveStatus(tx *bolt.Tx, st feedStatus) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(statusBucket))
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(st.URL), data)
+}
+
+// serveStatus exposes the current feedStatus for every feed as JSON, so
+// an operator (or monitoring) can see last fetch time, item counts and
+// last error without digging through the bolt database.
+func serveStatus(db *bolt.DB, addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		var statuses []feedStatus
+		db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte(statusBucket))
+			if b == nil {
+				return nil
+			}
+			return b.ForEach(func(k, v []byte) error {
+				var st feedStatus
+				if err := json.Unmarshal(v, &st); err != nil {
+					return nil
+				}
+				statuses = append(statuses, st)
+				return nil
+			})
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(statuses)
+	})
+
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		fmt.Fprintf(os.Stderr, "status server failed: %v\n", err)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SlyMarbo/rss"
+)
+
+func TestBackoff(t *testing.T) {
+	orig := flags.PollInterval
+	flags.PollInterval = time.Minute
+	defer func() { flags.PollInterval = orig }()
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, time.Minute},
+		{1, 2 * time.Minute},
+		{2, 4 * time.Minute},
+		{10, time.Hour}, // capped
+	}
+	for _, tt := range tests {
+		got := time.Until(backoff(tt.failures))
+		if got < tt.want-time.Second || got > tt.want+time.Second {
+			t.Errorf("backoff(%d) ~= %v, want ~%v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestNextFetch(t *testing.T) {
+	orig := flags.PollInterval
+	flags.PollInterval = 30 * time.Minute
+	defer func() { flags.PollInterval = orig }()
+
+	t.Run("falls back to poll-interval", func(t *testing.T) {
+		fc := &feedConfig{URL: "http://example.com/feed"}
+		f := &rss.Feed{}
+		got := time.Until(nextFetch(fc, f, 0))
+		if got < 29*time.Minute || got > 30*time.Minute+time.Second {
+			t.Errorf("nextFetch ~= %v, want ~30m", got)
+		}
+	})
+
+	t.Run("prefers the feed's own ttl", func(t *testing.T) {
+		fc := &feedConfig{URL: "http://example.com/feed"}
+		f := &rss.Feed{Refresh: time.Now().Add(5 * time.Minute)}
+		got := time.Until(nextFetch(fc, f, 20*time.Minute))
+		if got < 4*time.Minute || got > 5*time.Minute+time.Second {
+			t.Errorf("nextFetch ~= %v, want ~5m", got)
+		}
+	})
+
+	t.Run("falls back to Cache-Control max-age when the feed has no ttl", func(t *testing.T) {
+		fc := &feedConfig{URL: "http://example.com/feed"}
+		f := &rss.Feed{}
+		got := time.Until(nextFetch(fc, f, 10*time.Minute))
+		if got < 9*time.Minute || got > 10*time.Minute+time.Second {
+			t.Errorf("nextFetch ~= %v, want ~10m", got)
+		}
+	})
+
+	t.Run("never polls sooner than min-interval", func(t *testing.T) {
+		fc := &feedConfig{URL: "http://example.com/feed", MinInterval: time.Hour}
+		f := &rss.Feed{Refresh: time.Now().Add(5 * time.Minute)}
+		got := time.Until(nextFetch(fc, f, 0))
+		if got < 59*time.Minute || got > time.Hour+time.Second {
+			t.Errorf("nextFetch ~= %v, want ~1h", got)
+		}
+	})
+}
+
+func TestCacheControlMaxAge(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 0},
+		{"no-cache", 0},
+		{"max-age=0", 0},
+		{"max-age=300", 5 * time.Minute},
+		{"public, max-age=300", 5 * time.Minute},
+		{"max-age=not-a-number", 0},
+	}
+	for _, tt := range tests {
+		if got := cacheControlMaxAge(tt.header); got != tt.want {
+			t.Errorf("cacheControlMaxAge(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/emersion/go-imap"
+	imapclient "github.com/emersion/go-imap/client"
+)
+
+// imapNotifier delivers updates the way feed2imap does: instead of
+// coalescing items into a digest e-mail, it APPENDs one message per new
+// item straight into an IMAP mailbox, so any IMAP client becomes an RSS
+// reader. Each item's Message-Id is set to its feed item ID, and Notify
+// checks for an existing message with that Message-Id before APPENDing,
+// so re-delivering an item (e.g. after the content cache is cleared)
+// doesn't show up twice.
+type imapNotifier struct{}
+
+func (n *imapNotifier) Notify(ctx context.Context, updates []*update) error {
+	c, err := dialIMAP()
+	if err != nil {
+		return fmt.Errorf("connecting to %s: %w", flags.ImapAddr, err)
+	}
+	defer c.Logout()
+
+	if flags.ImapUser != "" {
+		if err := c.Login(flags.ImapUser, flags.ImapPass); err != nil {
+			return fmt.Errorf("authenticating: %w", err)
+		}
+	}
+
+	var errs []string
+	for _, u := range updates {
+		folder, err := renderImapFolder(u)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: rendering folder: %v", u.Title, err))
+			continue
+		}
+		if err := ensureMailbox(c, folder); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", folder, err))
+			continue
+		}
+		if _, err := c.Select(folder, false); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: selecting mailbox: %v", folder, err))
+			continue
+		}
+
+		for _, item := range u.Items {
+			exists, err := messageWithIDExists(c, imapMessageID(item.ID))
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: checking %q: %v", folder, item.Link, err))
+				continue
+			}
+			if exists {
+				continue
+			}
+
+			msg := buildImapMessage(u, item)
+			literal := bytes.NewReader(msg)
+			if err := c.Append(folder, nil, item.Date, literal); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: appending %q: %v", folder, item.Link, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// imapFolderData is what -imap-folder (or a feed's Folder override) is
+// executed against.
+type imapFolderData struct {
+	Title    string
+	Category string
+}
+
+// renderImapFolder renders u's destination mailbox name, preferring its
+// per-feed Folder override over -imap-folder.
+func renderImapFolder(u *update) (string, error) {
+	tmplStr := u.Folder
+	if tmplStr == "" {
+		tmplStr = flags.ImapFolder
+	}
+	t, err := texttemplate.New("imap-folder").Parse(tmplStr)
+	if err != nil {
+		return "", err
+	}
+	buf := &bytes.Buffer{}
+	if err := t.Execute(buf, imapFolderData{Title: u.Title, Category: u.Category}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// ensureMailbox creates folder if it doesn't already exist.
+func ensureMailbox(c *imapclient.Client, folder string) error {
+	mailboxes := make(chan *imap.MailboxInfo, 10)
+	done := make(chan error, 1)
+	go func() { done <- c.List("", folder, mailboxes) }()
+
+	var found bool
+	for range mailboxes {
+		found = true
+	}
+	if err := <-done; err != nil {
+		return fmt.Errorf("listing mailbox: %w", err)
+	}
+	if found {
+		return nil
+	}
+	if err := c.Create(folder); err != nil {
+		return fmt.Errorf("creating mailbox: %w", err)
+	}
+	return nil
+}
+
+// messageWithIDExists reports whether the currently selected mailbox
+// already has a message with the given Message-Id, so Notify can skip
+// re-appending an item it's delivered before.
+func messageWithIDExists(c *imapclient.Client, messageID string) (bool, error) {
+	criteria := imap.NewSearchCriteria()
+	criteria.Header.Set("Message-Id", messageID)
+	ids, err := c.Search(criteria)
+	if err != nil {
+		return false, err
+	}
+	return len(ids) > 0, nil
+}
+
+// imapMessageID formats a feed item ID as the Message-Id header value
+// buildImapMessage sets and messageWithIDExists searches for.
+func imapMessageID(id string) string {
+	return fmt.Sprintf("<%s@rssnotify>", id)
+}
+
+// headerSafe strips CR and LF from v before it's interpolated into a raw
+// RFC 822 header line. Feed titles come straight from untrusted remote
+// XML, and encoding/xml doesn't strip embedded newlines from element
+// text, so a title containing "\r\n" could otherwise forge extra
+// headers or smuggle content into the message body.
+func headerSafe(v string) string {
+	v = strings.ReplaceAll(v, "\r", "")
+	v = strings.ReplaceAll(v, "\n", "")
+	return v
+}
+
+// buildImapMessage renders item as an RFC 822 message: its Message-Id is
+// the feed item's ID, its body is the fetched article (if any) or just
+// the link.
+func buildImapMessage(u *update, item *enrichedItem) []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "Message-Id: %s\r\n", imapMessageID(item.ID))
+	fmt.Fprintf(buf, "Date: %s\r\n", item.Date.Format(time.RFC1123Z))
+	fmt.Fprintf(buf, "From: %s\r\n", headerSafe(u.Title))
+	fmt.Fprintf(buf, "Subject: %s\r\n", headerSafe(item.Title))
+	fmt.Fprintf(buf, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+
+	if item.ContentMarkdown != "" {
+		fmt.Fprintf(buf, "%s\n\n%s\n", item.ContentMarkdown, item.Link)
+	} else {
+		fmt.Fprintf(buf, "%s\n", item.Link)
+	}
+	return buf.Bytes()
+}
+
+// dialIMAP connects to -imap-addr, using an upfront TLS handshake unless
+// -imap-tls=none.
+func dialIMAP() (*imapclient.Client, error) {
+	if flags.ImapTLS == "none" {
+		return imapclient.Dial(flags.ImapAddr)
+	}
+
+	host, _, err := net.SplitHostPort(flags.ImapAddr)
+	if err != nil {
+		host = flags.ImapAddr
+	}
+	return imapclient.DialTLS(flags.ImapAddr, &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: flags.ImapInsecureSkipVerify,
+	})
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SlyMarbo/rss"
+)
+
+func TestRenderImapFolder(t *testing.T) {
+	orig := flags.ImapFolder
+	flags.ImapFolder = "feeds/{{.Category}}"
+	defer func() { flags.ImapFolder = orig }()
+
+	t.Run("falls back to -imap-folder", func(t *testing.T) {
+		u := &update{Title: "Example", Category: "tech"}
+		got, err := renderImapFolder(u)
+		if err != nil {
+			t.Fatalf("renderImapFolder: %v", err)
+		}
+		if want := "feeds/tech"; got != want {
+			t.Errorf("folder = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("prefers the update's own Folder override", func(t *testing.T) {
+		u := &update{Title: "Example", Category: "tech", Folder: "inbox/{{.Title}}"}
+		got, err := renderImapFolder(u)
+		if err != nil {
+			t.Fatalf("renderImapFolder: %v", err)
+		}
+		if want := "inbox/Example"; got != want {
+			t.Errorf("folder = %q, want %q", got, want)
+		}
+	})
+}
+
+func TestBuildImapMessage(t *testing.T) {
+	u := &update{Title: "Example Feed"}
+	item := &enrichedItem{
+		Item: &rss.Item{
+			ID:    "item-1",
+			Title: "Hello, world",
+			Link:  "http://example.com/1",
+			Date:  time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		},
+	}
+
+	msg := string(buildImapMessage(u, item))
+
+	for _, want := range []string{
+		"Message-Id: <item-1@rssnotify>\r\n",
+		"From: Example Feed\r\n",
+		"Subject: Hello, world\r\n",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message = %q, want it to contain %q", msg, want)
+		}
+	}
+	if !strings.Contains(msg, item.Link) {
+		t.Errorf("message = %q, want it to contain the link %q", msg, item.Link)
+	}
+}
+
+func TestBuildImapMessageSanitizesHeaders(t *testing.T) {
+	u := &update{Title: "Evil\r\nX-Injected: 1\r\n\r\n<script>evil body"}
+	item := &enrichedItem{
+		Item: &rss.Item{
+			ID:    "item-1",
+			Title: "Safe title\r\nX-Injected-Subject: 1",
+			Link:  "http://example.com/1",
+			Date:  time.Now(),
+		},
+	}
+
+	msg := string(buildImapMessage(u, item))
+
+	// Exactly one header/body separator means the feed-derived CR/LF
+	// didn't open a second header block (or close the header section
+	// early); if it had, headerSep would find more than one.
+	if n := strings.Count(msg, "\r\n\r\n"); n != 1 {
+		t.Errorf("message = %q, want exactly one header/body separator, got %d", msg, n)
+	}
+	headers := strings.Split(strings.SplitN(msg, "\r\n\r\n", 2)[0], "\r\n")
+	for _, h := range headers {
+		if strings.HasPrefix(h, "X-Injected") {
+			t.Errorf("message = %q, feed-derived CR/LF injected a header line %q", msg, h)
+		}
+	}
+	if !strings.Contains(msg, "From: EvilX-Injected: 1<script>evil body\r\n") {
+		t.Errorf("message = %q, want the From line stripped of embedded CR/LF", msg)
+	}
+}
+
+func TestHeaderSafe(t *testing.T) {
+	got := headerSafe("Evil\r\nX-Injected: 1\r\n\r\nbody")
+	want := "EvilX-Injected: 1body"
+	if got != want {
+		t.Errorf("headerSafe = %q, want %q", got, want)
+	}
+}
@@ -3,15 +3,14 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"html/template"
-	"net/smtp"
 	"os"
 	"os/user"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/SlyMarbo/rss"
@@ -20,16 +19,68 @@ import (
 
 type update struct {
 	Title string
-	Items []*rss.Item
+	Items []*enrichedItem
+
+	// Sinks restricts which -notify sinks this update is delivered to.
+	// Empty means "all of them".
+	Sinks []string
+
+	// To, Category and Template come from the feed's config entry and
+	// let the smtp notifier route and render different feeds
+	// differently; see smtpNotifier.Notify.
+	To       []string
+	Category string
+	Template string
+
+	// Folder overrides -imap-folder for this update's IMAP deliveries;
+	// see imapNotifier.Notify.
+	Folder string
+
+	// FetchContent, from the feed's config entry, tells enrichUpdates
+	// whether to fetch and convert each item's full article body.
+	FetchContent bool
 }
 
-// emailTemplate is the message template we'll use for generating the
-// e-mail message.
-var emailTemplate = template.Must(template.New("email").Funcs(template.FuncMap{
+// stringListFlag is a flag.Value accumulating a comma-separated and/or
+// repeated list of strings, e.g. -to a@x.com,b@x.com -to c@x.com. The
+// first Set call replaces any default so a flag.Var default value isn't
+// stuck alongside whatever the user passes.
+type stringListFlag struct {
+	values []string
+	set    bool
+}
+
+func (s *stringListFlag) String() string {
+	if s == nil {
+		return ""
+	}
+	return strings.Join(s.values, ",")
+}
+
+func (s *stringListFlag) Set(v string) error {
+	if !s.set {
+		s.values = nil
+		s.set = true
+	}
+	for _, part := range strings.Split(v, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			s.values = append(s.values, part)
+		}
+	}
+	return nil
+}
+
+// templateFuncs is shared between emailTemplate and any per-feed
+// template loaded via loadTemplate.
+var templateFuncs = template.FuncMap{
 	"formattedDate": func(t time.Time) string {
 		return t.Format("2006-02-01 15:04:05")
 	},
-}).Parse(`To: {{.To}}
+}
+
+// emailTemplate is the message template we'll use for generating the
+// e-mail message when a feed doesn't specify its own.
+var emailTemplate = template.Must(template.New("email").Funcs(templateFuncs).Parse(`To: {{.To}}
 From: {{.From}}
 Subject: {{.Subject}}
 
@@ -49,11 +100,49 @@ var (
 	// flags is a struct to store flag values. We do this so we can easily
 	// pass the information to the template engine.
 	flags struct {
-		To      string
+		To      stringListFlag
 		From    string
 		Subject string
 		Addr    string
 		Updates []*update
+
+		// SMTP delivery.
+		SMTPUser               string
+		SMTPPass               string
+		SMTPAuth               string
+		SMTPTLS                string
+		SMTPInsecureSkipVerify bool
+		BatchSize              int
+
+		// Daemon mode.
+		Daemon       bool
+		PollInterval time.Duration
+		Digest       time.Duration
+		StatusAddr   string
+		Fetchers     int
+
+		// Notification sinks.
+		Notify            string
+		TelegramToken     string
+		TelegramChatID    string
+		DiscordWebhookURL string
+		WebhookURL        string
+
+		// IMAP delivery.
+		ImapAddr               string
+		ImapUser               string
+		ImapPass               string
+		ImapTLS                string
+		ImapInsecureSkipVerify bool
+		ImapFolder             string
+
+		// OPML migration.
+		OPMLExport string
+		OPMLImport string
+
+		// Full-article fetching.
+		FetchContent    bool
+		MaxContentBytes int64
 	}
 
 	// Additional configs for local files.
@@ -71,25 +160,123 @@ func init() {
 		u = u + "@" + host
 	}
 
-	flag.StringVar(&flags.To, "to", u, "the name to send the e-mails as.")
-	flag.StringVar(&flags.From, "from", u, "the name to send the e-mails to.")
+	flags.To.values = []string{u}
+	flag.Var(&flags.To, "to", "who to send the e-mails to; repeat the flag or comma-separate for multiple recipients.")
+	flag.StringVar(&flags.From, "from", u, "the name to send the e-mails as.")
 	flag.StringVar(&flags.Subject, "subject", "[rssnotify] Updated Feeds",
 		"the subject of the e-mails.")
 	flag.StringVar(&flags.Addr, "addr", "localhost:smtp",
 		"the SMTP server to use to send the e-mail.")
 
+	flag.StringVar(&flags.SMTPUser, "smtp-user", "", "username for SMTP authentication.")
+	flag.StringVar(&flags.SMTPPass, "smtp-pass", "", "password for SMTP authentication.")
+	flag.StringVar(&flags.SMTPAuth, "smtp-auth", "plain",
+		"SMTP authentication mechanism to use when -smtp-user is set: plain, cram-md5, or none.")
+	flag.StringVar(&flags.SMTPTLS, "smtp-tls", "none",
+		"how to use TLS when connecting to the SMTP server: none, starttls, or tls.")
+	flag.BoolVar(&flags.SMTPInsecureSkipVerify, "smtp-insecure-skip-verify", false,
+		"skip verifying the SMTP server's TLS certificate.")
+	flag.IntVar(&flags.BatchSize, "batch-size", 50,
+		"maximum recipients to RCPT TO in a single SMTP transaction; larger recipient lists are sent in batches.")
+
 	flag.StringVar(&feedsFile, "feeds", os.ExpandEnv("$HOME/.config/rssnotify/feeds"),
 		"besides using command line arguments, also get a feed list from this file.")
 	flag.StringVar(&dbFile, "db", os.ExpandEnv("$HOME/.local/share/rssnotify/db"),
 		"the location of the database where feed history is stored.")
+
+	flag.BoolVar(&flags.Daemon, "daemon", false,
+		"run continuously instead of exiting after one pass, polling each feed at its own interval.")
+	flag.DurationVar(&flags.PollInterval, "poll-interval", 30*time.Minute,
+		"default polling interval for feeds that don't advertise their own <ttl> or Cache-Control: max-age (daemon mode only).")
+	flag.DurationVar(&flags.Digest, "digest", time.Hour,
+		"how often to coalesce collected updates into a digest e-mail (daemon mode only).")
+	flag.StringVar(&flags.StatusAddr, "status-addr", "",
+		"if set, serve an HTTP status page on this address listing feeds, last fetch time, item counts and last error (daemon mode only).")
+	flag.IntVar(&flags.Fetchers, "fetchers", 20,
+		"number of concurrent fetcher goroutines to use in -daemon mode.")
+
+	flag.StringVar(&flags.Notify, "notify", "smtp",
+		"comma-separated list of notification sinks to deliver updates to (smtp, imap, telegram, discord, webhook, stdout).")
+	flag.StringVar(&flags.TelegramToken, "telegram-token", "",
+		"bot token for the telegram notifier.")
+	flag.StringVar(&flags.TelegramChatID, "telegram-chat-id", "",
+		"chat ID for the telegram notifier to deliver to.")
+	flag.StringVar(&flags.DiscordWebhookURL, "discord-webhook-url", "",
+		"Discord webhook URL for the discord notifier.")
+	flag.StringVar(&flags.WebhookURL, "webhook-url", "",
+		"URL to POST a JSON payload to for the webhook notifier.")
+
+	flag.StringVar(&flags.ImapAddr, "imap-addr", "",
+		"IMAP server address (host:port) for the imap notifier, which appends each new item as a message instead of mailing a digest.")
+	flag.StringVar(&flags.ImapUser, "imap-user", "", "username for IMAP authentication.")
+	flag.StringVar(&flags.ImapPass, "imap-pass", "", "password for IMAP authentication.")
+	flag.StringVar(&flags.ImapTLS, "imap-tls", "tls",
+		"how to use TLS when connecting to the IMAP server: tls or none.")
+	flag.BoolVar(&flags.ImapInsecureSkipVerify, "imap-insecure-skip-verify", false,
+		"skip verifying the IMAP server's TLS certificate.")
+	flag.StringVar(&flags.ImapFolder, "imap-folder", "INBOX/Feeds/{{.Title}}",
+		"Go template for the mailbox each feed's items are appended to (fields: Title, Category); created on demand.")
+
+	flag.StringVar(&flags.OPMLExport, "opml-export", "",
+		"write the configured feeds out as an OPML file at this path, then exit, instead of checking for updates.")
+	flag.StringVar(&flags.OPMLImport, "opml-import", "",
+		"read feeds from this OPML file and print them as a YAML feeds file to stdout, then exit, instead of checking for updates.")
+
+	flag.BoolVar(&flags.FetchContent, "fetch-content", false,
+		"fetch each item's full article body and include it (converted to Markdown/HTML) in the digest e-mail, instead of just the link.")
+	flag.Int64Var(&flags.MaxContentBytes, "max-content-bytes", 1<<20,
+		"maximum bytes to read of a fetched article body.")
 }
 
 func main() {
 	flag.Parse()
 
-	// Open up our bolt database.
-	err := os.MkdirAll(path.Dir(dbFile), 0700)
+	// Get our feed list.
+	feeds, err := parseFeedsFile()
 	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed parsing feeds file: %v\n", err)
+		os.Exit(1)
+	}
+	for _, url := range flag.Args() {
+		feeds = append(feeds, &feedConfig{URL: url})
+	}
+
+	// OPML import/export are one-off conversions, not part of the
+	// normal check-for-updates run.
+	if flags.OPMLExport != "" {
+		data, err := feedsToOPML(feeds)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed generating OPML: %v\n", err)
+			os.Exit(1)
+		}
+		if err := os.WriteFile(flags.OPMLExport, data, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "failed writing OPML: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if flags.OPMLImport != "" {
+		data, err := os.ReadFile(flags.OPMLImport)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed reading OPML: %v\n", err)
+			os.Exit(1)
+		}
+		imported, err := parseOPMLFeeds(data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed parsing OPML: %v\n", err)
+			os.Exit(1)
+		}
+		out, err := feedsToYAML(imported)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed encoding feeds as YAML: %v\n", err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(out)
+		return
+	}
+
+	// Open up our bolt database.
+	if err := os.MkdirAll(path.Dir(dbFile), 0700); err != nil {
 		fmt.Fprintf(os.Stderr, "failed to make directory for db: %v\n", err)
 		os.Exit(1)
 	}
@@ -100,18 +287,25 @@ func main() {
 	}
 	defer db.Close()
 
-	// Get our feed list.
-	feeds := parseFeedsFile(nil)
-	feeds = append(feeds, flag.Args()...)
+	// In daemon mode we never reach the one-shot cron path below; we poll
+	// each feed on its own schedule and coalesce updates into digests
+	// until the process is killed.
+	if flags.Daemon {
+		if err := runDaemon(db, feeds); err != nil {
+			fmt.Fprintf(os.Stderr, "daemon exited: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	// Check for any updates.
 	err = db.Update(func(tx *bolt.Tx) error {
 		// Loop through the feed list.
-		for _, feed := range feeds {
+		for _, fc := range feeds {
 			// Get the feed data.
-			f, err := rss.Fetch(feed)
+			f, err := rss.Fetch(fc.URL)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "failed fetching feed '%v': %v\n", feed, err)
+				fmt.Fprintf(os.Stderr, "failed fetching feed '%v': %v\n", fc.URL, err)
 				continue
 			}
 
@@ -123,19 +317,7 @@ func main() {
 			}
 
 			// Check for updates to the feeds.
-			upd := &update{
-				Title: f.Title,
-			}
-			for _, item := range f.Items {
-				// Check to see if we already have it.
-				if bucket.Get([]byte(item.ID)) != nil {
-					continue
-				}
-
-				// Add the item to our list and mark it read.
-				upd.Items = append(upd.Items, item)
-				err = bucket.Put([]byte(item.ID), []byte("1"))
-			}
+			upd := collectUpdate(bucket, f, fc)
 
 			// Check to see if we added any and include it in our updates.
 			if len(upd.Items) > 0 {
@@ -154,44 +336,48 @@ func main() {
 		os.Exit(0)
 	}
 
-	// Execute the e-mail template.
-	buf := &bytes.Buffer{}
-	if err := emailTemplate.Execute(buf, &flags); err != nil {
-		fmt.Fprintf(os.Stderr, "failed executing template: %v\n", err)
+	enrichUpdates(db, flags.Updates)
+
+	notifiers, err := buildNotifiers(flags.Notify)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed configuring notifiers: %v\n", err)
 		os.Exit(1)
 	}
 
-	// TODO allow for authentication.
-	// TODO allow multiple To's.
-	// Send the message.
-	err = smtp.SendMail(flags.Addr, nil, flags.From, []string{flags.To}, buf.Bytes())
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed sending message: %v\n", err)
+	if err := notifyAll(context.Background(), notifiers, flags.Updates); err != nil {
+		fmt.Fprintf(os.Stderr, "failed sending notifications: %v\n", err)
 		os.Exit(1)
 	}
 }
 
-func parseFeedsFile(feeds []string) []string {
-	// Don't parse if it doesn't exists. We do this here because it's
-	// not an error we want to report.
-	if _, err := os.Stat(feedsFile); os.IsNotExist(err) {
-		return nil
+// collectUpdate checks a freshly fetched feed against bucket (the bolt
+// bucket tracking item IDs we've already seen for it), marks any new
+// items as seen, and returns the ones that pass fc's include/exclude
+// filters as an update routed and labelled per fc.
+func collectUpdate(bucket *bolt.Bucket, f *rss.Feed, fc *feedConfig) *update {
+	upd := &update{
+		Title:        fc.title(f.Title),
+		Sinks:        fc.Sinks,
+		To:           fc.To,
+		Category:     fc.Category,
+		Template:     fc.Template,
+		Folder:       fc.Folder,
+		FetchContent: fc.fetchContent(),
 	}
+	for _, item := range f.Items {
+		// Check to see if we already have it.
+		if bucket.Get([]byte(item.ID)) != nil {
+			continue
+		}
 
-	f, err := os.Open(feedsFile)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed opening feedsFile '%v': %v", feedsFile, err)
-		os.Exit(1)
-	}
-	defer f.Close()
+		// Mark it read regardless of whether it passes the filters, so we
+		// don't keep re-evaluating it on every future fetch.
+		bucket.Put([]byte(item.ID), []byte("1"))
 
-	s := bufio.NewScanner(f)
-	for s.Scan() {
-		feeds = append(feeds, s.Text())
-	}
-	if err := s.Err(); err != nil {
-		fmt.Fprintf(os.Stderr, "failed parsing feedsFile '%v': %v", feedsFile, err)
-		os.Exit(1)
+		if !fc.matches(item.Title, item.Categories) {
+			continue
+		}
+		upd.Items = append(upd.Items, &enrichedItem{Item: item})
 	}
-	return feeds
+	return upd
 }
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Notifier delivers a batch of feed updates to some destination, be it
+// e-mail, a chat service, a generic webhook, or stdout.
+type Notifier interface {
+	Notify(ctx context.Context, updates []*update) error
+}
+
+// namedNotifier pairs a Notifier with the sink name it was built from
+// (as used in -notify and in a feed's Sinks list), so routing can filter
+// updates per sink before calling Notify.
+type namedNotifier struct {
+	name string
+	Notifier
+}
+
+// buildNotifiers resolves the comma-separated sink list passed to
+// -notify into the Notifiers that implement them.
+func buildNotifiers(names string) ([]namedNotifier, error) {
+	var notifiers []namedNotifier
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		switch name {
+		case "smtp":
+			notifiers = append(notifiers, namedNotifier{name, &smtpNotifier{}})
+		case "stdout":
+			notifiers = append(notifiers, namedNotifier{name, &stdoutNotifier{}})
+		case "telegram":
+			if flags.TelegramToken == "" || flags.TelegramChatID == "" {
+				return nil, fmt.Errorf("telegram notifier requires -telegram-token and -telegram-chat-id")
+			}
+			notifiers = append(notifiers, namedNotifier{name, &telegramNotifier{
+				token:  flags.TelegramToken,
+				chatID: flags.TelegramChatID,
+			}})
+		case "discord":
+			if flags.DiscordWebhookURL == "" {
+				return nil, fmt.Errorf("discord notifier requires -discord-webhook-url")
+			}
+			notifiers = append(notifiers, namedNotifier{name, &discordNotifier{url: flags.DiscordWebhookURL}})
+		case "webhook":
+			if flags.WebhookURL == "" {
+				return nil, fmt.Errorf("webhook notifier requires -webhook-url")
+			}
+			notifiers = append(notifiers, namedNotifier{name, &webhookNotifier{url: flags.WebhookURL}})
+		case "imap":
+			if flags.ImapAddr == "" {
+				return nil, fmt.Errorf("imap notifier requires -imap-addr")
+			}
+			notifiers = append(notifiers, namedNotifier{name, &imapNotifier{}})
+		default:
+			return nil, fmt.Errorf("unknown -notify sink %q", name)
+		}
+	}
+	return notifiers, nil
+}
+
+// notifyAll routes updates to every configured notifier, honoring each
+// update's optional per-feed Sinks list, and returns a combined error if
+// any notifier failed (the rest are still attempted).
+func notifyAll(ctx context.Context, notifiers []namedNotifier, updates []*update) error {
+	var errs []string
+	for _, n := range notifiers {
+		sub := updatesFor(n.name, updates)
+		if len(sub) == 0 {
+			continue
+		}
+		if err := n.Notify(ctx, sub); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", n.name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// updatesFor filters updates down to the ones routed to sink. An update
+// with no Sinks set (the common case) goes to every configured
+// notifier; one with Sinks set only goes to the ones it names.
+func updatesFor(sink string, updates []*update) []*update {
+	var out []*update
+	for _, u := range updates {
+		if len(u.Sinks) == 0 || contains(u.Sinks, sink) {
+			out = append(out, u)
+		}
+	}
+	return out
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// smtpNotifier renders updates and sends them as e-mail, grouping
+// updates that share a recipient list and template into a single
+// message so feeds can be delivered to different addresses (or with a
+// different template) per the feeds config.
+type smtpNotifier struct{}
+
+// emailData is what emailTemplate (or a per-feed template) is executed
+// against.
+type emailData struct {
+	To      string
+	From    string
+	Subject string
+	Updates []*update
+}
+
+func (n *smtpNotifier) Notify(ctx context.Context, updates []*update) error {
+	var errs []string
+	for _, g := range groupByRecipient(updates) {
+		to := g.to
+		if len(to) == 0 {
+			to = flags.To.values
+		}
+
+		// render is called once per -batch-size RCPT batch (see
+		// sendMail) rather than once for the whole group, so a batch's
+		// own To: header only ever names the recipients it was
+		// actually sent to.
+		render := func(batch []string) ([]byte, error) {
+			data := emailData{To: strings.Join(batch, ", "), From: flags.From, Subject: flags.Subject, Updates: g.updates}
+			switch {
+			case g.template != "":
+				return renderTemplate(g.template, data)
+			case hasContent(g.updates):
+				// At least one item has a fetched article body: send a
+				// multipart/alternative e-mail with Markdown and HTML parts
+				// instead of the plain link-only digest.
+				return renderMultipart(data)
+			default:
+				return renderTemplate("", data)
+			}
+		}
+
+		if err := sendMail(flags.Addr, flags.From, to, render); err != nil {
+			errs = append(errs, fmt.Sprintf("sending to %v: %v", to, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// renderTemplate renders data with the e-mail template at path, or with
+// the default emailTemplate when path is empty.
+func renderTemplate(path string, data emailData) ([]byte, error) {
+	tmpl := emailTemplate
+	if path != "" {
+		t, err := loadTemplate(path)
+		if err != nil {
+			return nil, fmt.Errorf("template %q: %w", path, err)
+		}
+		tmpl = t
+	}
+
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// renderMultipart renders data's text and HTML digests and wraps them in
+// a multipart/alternative message, so subscribers with a fetched
+// article body get its actual content instead of just a link.
+func renderMultipart(data emailData) ([]byte, error) {
+	textBuf := &bytes.Buffer{}
+	if err := textDigestTemplate.Execute(textBuf, data); err != nil {
+		return nil, fmt.Errorf("rendering text part: %w", err)
+	}
+	htmlBuf := &bytes.Buffer{}
+	if err := htmlDigestTemplate.Execute(htmlBuf, data); err != nil {
+		return nil, fmt.Errorf("rendering html part: %w", err)
+	}
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+
+	textPart, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := textPart.Write(textBuf.Bytes()); err != nil {
+		return nil, err
+	}
+
+	htmlPart, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/html; charset=utf-8"}})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := htmlPart.Write(htmlBuf.Bytes()); err != nil {
+		return nil, err
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	header := fmt.Sprintf("To: %s\r\nFrom: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: multipart/alternative; boundary=%q\r\n\r\n",
+		data.To, data.From, data.Subject, mw.Boundary())
+	return append([]byte(header), body.Bytes()...), nil
+}
+
+// recipientGroup is a batch of updates that share a recipient list and
+// template, and so are rendered into a single e-mail.
+type recipientGroup struct {
+	to       []string
+	template string
+	updates  []*update
+}
+
+// groupByRecipient partitions updates by (To, Template), preserving the
+// order each group was first seen in.
+func groupByRecipient(updates []*update) []*recipientGroup {
+	byKey := map[string]*recipientGroup{}
+	var order []string
+	for _, u := range updates {
+		key := strings.Join(u.To, ",") + "\x00" + u.Template
+		g, ok := byKey[key]
+		if !ok {
+			g = &recipientGroup{to: u.To, template: u.Template}
+			byKey[key] = g
+			order = append(order, key)
+		}
+		g.updates = append(g.updates, u)
+	}
+
+	groups := make([]*recipientGroup, len(order))
+	for i, key := range order {
+		groups[i] = byKey[key]
+	}
+	return groups
+}
+
+var (
+	templateCacheMu sync.Mutex
+	templateCache   = map[string]*template.Template{}
+)
+
+// loadTemplate loads and caches a per-feed e-mail template from disk.
+func loadTemplate(path string) (*template.Template, error) {
+	templateCacheMu.Lock()
+	defer templateCacheMu.Unlock()
+
+	if t, ok := templateCache[path]; ok {
+		return t, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	t, err := template.New(filepath.Base(path)).Funcs(templateFuncs).Parse(string(data))
+	if err != nil {
+		return nil, err
+	}
+	templateCache[path] = t
+	return t, nil
+}
+
+// stdoutNotifier just prints updates, useful for testing -notify
+// routing without sending any real notifications.
+type stdoutNotifier struct{}
+
+func (n *stdoutNotifier) Notify(ctx context.Context, updates []*update) error {
+	for _, u := range updates {
+		fmt.Printf("* %s\n", u.Title)
+		for _, item := range u.Items {
+			fmt.Printf("  %s - %s\n", item.Title, item.Link)
+		}
+	}
+	return nil
+}
+
+// telegramNotifier delivers each item as a message via the Telegram Bot
+// API's sendMessage call.
+type telegramNotifier struct {
+	token  string
+	chatID string
+}
+
+func (n *telegramNotifier) Notify(ctx context.Context, updates []*update) error {
+	for _, u := range updates {
+		for _, item := range u.Items {
+			text := fmt.Sprintf("%s\n%s\n%s", u.Title, item.Title, item.Link)
+			if err := postJSON(ctx, fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", n.token),
+				map[string]string{"chat_id": n.chatID, "text": text}); err != nil {
+				return fmt.Errorf("telegram: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// discordNotifier posts each item to a Discord incoming webhook.
+type discordNotifier struct {
+	url string
+}
+
+func (n *discordNotifier) Notify(ctx context.Context, updates []*update) error {
+	for _, u := range updates {
+		for _, item := range u.Items {
+			content := fmt.Sprintf("**%s**\n%s\n%s", u.Title, item.Title, item.Link)
+			if err := postJSON(ctx, n.url, map[string]string{"content": content}); err != nil {
+				return fmt.Errorf("discord: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// webhookNotifier POSTs the raw updates as JSON to a generic endpoint,
+// for plugging rssnotify into anything that accepts a webhook (e.g. the
+// SSB bridge rss-butt-plug uses).
+type webhookNotifier struct {
+	url string
+}
+
+func (n *webhookNotifier) Notify(ctx context.Context, updates []*update) error {
+	if err := postJSON(ctx, n.url, updates); err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	return nil
+}
+
+// postJSON marshals v and POSTs it to url, returning an error if the
+// request fails or the response status isn't a 2xx.
+func postJSON(ctx context.Context, url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
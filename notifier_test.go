@@ -0,0 +1,71 @@
+package main
+
+import "testing"
+
+func TestBuildNotifiers(t *testing.T) {
+	notifiers, err := buildNotifiers("stdout, smtp")
+	if err != nil {
+		t.Fatalf("buildNotifiers: %v", err)
+	}
+	if len(notifiers) != 2 || notifiers[0].name != "stdout" || notifiers[1].name != "smtp" {
+		t.Errorf("notifiers = %+v, want [stdout smtp]", notifiers)
+	}
+
+	if _, err := buildNotifiers("bogus"); err == nil {
+		t.Error("expected an error for an unknown sink")
+	}
+
+	if _, err := buildNotifiers("telegram"); err == nil {
+		t.Error("expected an error for telegram without -telegram-token/-telegram-chat-id")
+	}
+}
+
+func TestBuildNotifiersEmpty(t *testing.T) {
+	notifiers, err := buildNotifiers("")
+	if err != nil || len(notifiers) != 0 {
+		t.Errorf("buildNotifiers(\"\") = %v, %v, want no notifiers and no error", notifiers, err)
+	}
+}
+
+func TestUpdatesFor(t *testing.T) {
+	all := &update{Title: "all sinks"}
+	smtpOnly := &update{Title: "smtp only", Sinks: []string{"smtp"}}
+	telegramOnly := &update{Title: "telegram only", Sinks: []string{"telegram"}}
+	updates := []*update{all, smtpOnly, telegramOnly}
+
+	got := updatesFor("smtp", updates)
+	if len(got) != 2 || got[0] != all || got[1] != smtpOnly {
+		t.Errorf("updatesFor(smtp) = %v, want [all smtpOnly]", got)
+	}
+
+	got = updatesFor("telegram", updates)
+	if len(got) != 2 || got[0] != all || got[1] != telegramOnly {
+		t.Errorf("updatesFor(telegram) = %v, want [all telegramOnly]", got)
+	}
+
+	got = updatesFor("discord", updates)
+	if len(got) != 1 || got[0] != all {
+		t.Errorf("updatesFor(discord) = %v, want [all]", got)
+	}
+}
+
+func TestGroupByRecipient(t *testing.T) {
+	u1 := &update{Title: "feed1", To: []string{"a@x.com"}}
+	u2 := &update{Title: "feed2", To: []string{"a@x.com"}}
+	u3 := &update{Title: "feed3", To: []string{"b@x.com"}, Template: "custom.tmpl"}
+	u4 := &update{Title: "feed4"}
+
+	groups := groupByRecipient([]*update{u1, u2, u3, u4})
+	if len(groups) != 3 {
+		t.Fatalf("got %d groups, want 3", len(groups))
+	}
+	if len(groups[0].updates) != 2 || groups[0].updates[0] != u1 || groups[0].updates[1] != u2 {
+		t.Errorf("group 0 = %+v, want u1+u2 grouped together", groups[0].updates)
+	}
+	if len(groups[1].updates) != 1 || groups[1].updates[0] != u3 {
+		t.Errorf("group 1 = %+v, want just u3", groups[1].updates)
+	}
+	if len(groups[2].updates) != 1 || groups[2].updates[0] != u4 {
+		t.Errorf("group 2 = %+v, want just u4", groups[2].updates)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import "encoding/xml"
+
+// opmlDocument is a minimal OPML 2.0 document, enough to round-trip the
+// subscription lists exported by standard feed readers.
+type opmlDocument struct {
+	XMLName xml.Name `xml:"opml"`
+	Version string   `xml:"version,attr"`
+	Head    opmlHead `xml:"head"`
+	Body    opmlBody `xml:"body"`
+}
+
+type opmlHead struct {
+	Title string `xml:"title"`
+}
+
+type opmlBody struct {
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+type opmlOutline struct {
+	Text     string        `xml:"text,attr"`
+	Title    string        `xml:"title,attr,omitempty"`
+	Type     string        `xml:"type,attr,omitempty"`
+	XMLURL   string        `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL  string        `xml:"htmlUrl,attr,omitempty"`
+	Outlines []opmlOutline `xml:"outline,omitempty"`
+}
+
+// parseOPMLFeeds flattens an OPML subscription list into feedConfigs.
+// Outlines with an xmlUrl become feeds; outlines without one are treated
+// as a category grouping their children (the usual way readers export
+// folders).
+func parseOPMLFeeds(data []byte) ([]*feedConfig, error) {
+	var doc opmlDocument
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	var feeds []*feedConfig
+	var walk func(outlines []opmlOutline, category string)
+	walk = func(outlines []opmlOutline, category string) {
+		for _, o := range outlines {
+			if o.XMLURL != "" {
+				feeds = append(feeds, &feedConfig{
+					URL:           o.XMLURL,
+					TitleOverride: o.Title,
+					Category:      category,
+				})
+				continue
+			}
+			walk(o.Outlines, o.Text)
+		}
+	}
+	walk(doc.Body.Outlines, "")
+	return feeds, nil
+}
+
+// feedsToOPML renders feeds as an OPML 2.0 document, grouping them into
+// category outlines so the result reads back cleanly in standard
+// readers.
+func feedsToOPML(feeds []*feedConfig) ([]byte, error) {
+	doc := opmlDocument{
+		Version: "2.0",
+		Head:    opmlHead{Title: "rssnotify feeds"},
+	}
+
+	var categories []string
+	byCategory := map[string][]opmlOutline{}
+	for _, fc := range feeds {
+		title := fc.title(fc.URL)
+		if _, ok := byCategory[fc.Category]; !ok {
+			categories = append(categories, fc.Category)
+		}
+		byCategory[fc.Category] = append(byCategory[fc.Category], opmlOutline{
+			Text:   title,
+			Title:  title,
+			Type:   "rss",
+			XMLURL: fc.URL,
+		})
+	}
+
+	for _, category := range categories {
+		if category == "" {
+			doc.Body.Outlines = append(doc.Body.Outlines, byCategory[category]...)
+			continue
+		}
+		doc.Body.Outlines = append(doc.Body.Outlines, opmlOutline{
+			Text:     category,
+			Title:    category,
+			Outlines: byCategory[category],
+		})
+	}
+
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+func TestParseOPMLFeeds(t *testing.T) {
+	data := []byte(`<?xml version="1.0"?>
+<opml version="2.0">
+  <head><title>feeds</title></head>
+  <body>
+    <outline text="Uncategorized" xmlUrl="http://example.com/a" title="A"/>
+    <outline text="Tech">
+      <outline text="Example B" xmlUrl="http://example.com/b" title="B"/>
+      <outline text="Example C" xmlUrl="http://example.com/c" title="C"/>
+    </outline>
+  </body>
+</opml>`)
+
+	feeds, err := parseOPMLFeeds(data)
+	if err != nil {
+		t.Fatalf("parseOPMLFeeds: %v", err)
+	}
+	if len(feeds) != 3 {
+		t.Fatalf("got %d feeds, want 3", len(feeds))
+	}
+	if feeds[0].URL != "http://example.com/a" || feeds[0].Category != "" {
+		t.Errorf("feed 0 = %+v, want top-level outline with no category", feeds[0])
+	}
+	if feeds[1].URL != "http://example.com/b" || feeds[1].Category != "Tech" || feeds[1].TitleOverride != "B" {
+		t.Errorf("feed 1 = %+v, want Tech category and title override B", feeds[1])
+	}
+	if feeds[2].URL != "http://example.com/c" || feeds[2].Category != "Tech" {
+		t.Errorf("feed 2 = %+v, want Tech category", feeds[2])
+	}
+}
+
+func TestFeedsToOPMLRoundTrip(t *testing.T) {
+	feeds := []*feedConfig{
+		{URL: "http://example.com/a"},
+		{URL: "http://example.com/b", TitleOverride: "B", Category: "Tech"},
+		{URL: "http://example.com/c", Category: "Tech"},
+	}
+
+	out, err := feedsToOPML(feeds)
+	if err != nil {
+		t.Fatalf("feedsToOPML: %v", err)
+	}
+
+	got, err := parseOPMLFeeds(out)
+	if err != nil {
+		t.Fatalf("parseOPMLFeeds(feedsToOPML(...)): %v", err)
+	}
+	if len(got) != len(feeds) {
+		t.Fatalf("got %d feeds after round-trip, want %d", len(got), len(feeds))
+	}
+	for i, fc := range feeds {
+		if got[i].URL != fc.URL || got[i].Category != fc.Category {
+			t.Errorf("feed %d = %+v, want url/category matching %+v", i, got[i], fc)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+// sendMail delivers to every address in to, batching RCPT TO's into
+// groups of -batch-size per SMTP transaction so one slow or oversized
+// recipient list doesn't blow past server limits. render is called once
+// per batch, rather than once overall, so each batch's message (and in
+// particular its own To: header) only ever names the recipients that
+// batch actually RCPT'd -- recipients in one batch must never see the
+// addresses of recipients in another. A batch with some rejected
+// recipients still delivers to the ones that were accepted; failures
+// across batches are collected rather than aborting the rest.
+func sendMail(addr, from string, to []string, render func(batch []string) ([]byte, error)) error {
+	if len(to) == 0 {
+		return fmt.Errorf("no recipients")
+	}
+
+	batchSize := flags.BatchSize
+	if batchSize < 1 {
+		batchSize = len(to)
+	}
+
+	var errs []string
+	for i := 0; i < len(to); i += batchSize {
+		end := i + batchSize
+		if end > len(to) {
+			end = len(to)
+		}
+		batch := to[i:end]
+
+		msg, err := render(batch)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("rendering for %v: %v", batch, err))
+			continue
+		}
+		if err := sendMailBatch(addr, from, batch, msg); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// sendMailBatch sends msg to one batch of recipients over a single SMTP
+// connection, authenticating and negotiating TLS per -smtp-tls first.
+func sendMailBatch(addr, from string, to []string, msg []byte) error {
+	c, err := dialSMTP(addr)
+	if err != nil {
+		return fmt.Errorf("dialing %s: %w", addr, err)
+	}
+	defer c.Close()
+
+	host := smtpHost(addr)
+
+	if flags.SMTPTLS == "starttls" {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("starttls: server at %s doesn't advertise STARTTLS", addr)
+		}
+		if err := c.StartTLS(&tls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: flags.SMTPInsecureSkipVerify,
+		}); err != nil {
+			return fmt.Errorf("starttls: %w", err)
+		}
+	}
+
+	if auth := smtpAuth(host); auth != nil {
+		if err := c.Auth(auth); err != nil {
+			return fmt.Errorf("authenticating: %w", err)
+		}
+	}
+
+	if err := c.Mail(from); err != nil {
+		return fmt.Errorf("MAIL FROM: %w", err)
+	}
+
+	var accepted []string
+	var rejected []string
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			rejected = append(rejected, fmt.Sprintf("%s: %v", rcpt, err))
+			continue
+		}
+		accepted = append(accepted, rcpt)
+	}
+	if len(accepted) == 0 {
+		return fmt.Errorf("no recipients accepted: %s", strings.Join(rejected, "; "))
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("DATA: %w", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("writing message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("closing message: %w", err)
+	}
+	if err := c.Quit(); err != nil {
+		return fmt.Errorf("quit: %w", err)
+	}
+
+	if len(rejected) > 0 {
+		return fmt.Errorf("some recipients rejected: %s", strings.Join(rejected, "; "))
+	}
+	return nil
+}
+
+// dialSMTP connects to addr, using an upfront TLS handshake when
+// -smtp-tls=tls (implicit TLS, e.g. port 465) rather than STARTTLS.
+func dialSMTP(addr string) (*smtp.Client, error) {
+	host := smtpHost(addr)
+
+	if flags.SMTPTLS == "tls" {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: flags.SMTPInsecureSkipVerify,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return smtp.NewClient(conn, host)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return smtp.NewClient(conn, host)
+}
+
+// smtpAuth builds the smtp.Auth for -smtp-auth, or nil if -smtp-user
+// isn't set (no authentication).
+func smtpAuth(host string) smtp.Auth {
+	if flags.SMTPUser == "" {
+		return nil
+	}
+	switch flags.SMTPAuth {
+	case "none":
+		return nil
+	case "cram-md5":
+		return smtp.CRAMMD5Auth(flags.SMTPUser, flags.SMTPPass)
+	default:
+		return smtp.PlainAuth("", flags.SMTPUser, flags.SMTPPass, host)
+	}
+}
+
+// smtpHost strips the port off addr for use as the TLS server name / in
+// PLAIN auth, falling back to addr itself if it has no port.
+func smtpHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestSendMailBatching checks that sendMail splits recipients into
+// -batch-size groups and renders each batch separately -- the render
+// callback exists specifically so a batch's own To: header never names
+// recipients from a different batch.
+func TestSendMailBatching(t *testing.T) {
+	orig := flags.BatchSize
+	flags.BatchSize = 2
+	defer func() { flags.BatchSize = orig }()
+
+	to := []string{"a@x.com", "b@x.com", "c@x.com", "d@x.com", "e@x.com"}
+	var gotBatches [][]string
+	render := func(batch []string) ([]byte, error) {
+		gotBatches = append(gotBatches, append([]string(nil), batch...))
+		return []byte("msg"), nil
+	}
+
+	// There's nothing listening at this address, so every batch will
+	// fail to dial; we only care that render saw the right batches.
+	err := sendMail("127.0.0.1:0", "from@x.com", to, render)
+	if err == nil {
+		t.Fatal("expected an error dialing a non-existent SMTP server")
+	}
+	if !strings.Contains(err.Error(), "dialing") {
+		t.Errorf("error = %v, want a dialing error", err)
+	}
+
+	want := [][]string{
+		{"a@x.com", "b@x.com"},
+		{"c@x.com", "d@x.com"},
+		{"e@x.com"},
+	}
+	if !reflect.DeepEqual(gotBatches, want) {
+		t.Errorf("batches = %v, want %v", gotBatches, want)
+	}
+}
+
+func TestSendMailNoRecipients(t *testing.T) {
+	if err := sendMail("127.0.0.1:0", "from@x.com", nil, nil); err == nil {
+		t.Fatal("expected an error for an empty recipient list")
+	}
+}